@@ -3,18 +3,30 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/buger/jsonparser"
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/time/rate"
 )
 
 var debugMode bool = false
@@ -42,17 +54,248 @@ const (
 	defaultScheme = "http"
 )
 
+const (
+	defaultConcurrency = 4
+	maxRetries         = 5
+	initialBackoff     = 500 * time.Millisecond
+	maxBackoff         = 30 * time.Second
+)
+
+// stringSliceFlag lets a flag be supplied multiple times, collecting each occurrence in order;
+// used for "-column" so several columns can be resolved in one pass.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// csvJob represents a single input record, tagged with its original row index so the
+// collector can write output rows back out in the order they were read.
+type csvJob struct {
+	index  int
+	record []string
+}
+
+// csvResult is the outcome of resolving a single csvJob, carrying the same row index as the job
+// so its output rows can be slotted back into their original position. A single input row can
+// expand into more than one output row when -expand=rows splits a multi-value cell.
+type csvResult struct {
+	index   int
+	records [][]string
+	skipped bool
+}
+
+// errorRecorder writes the -errors-out companion CSV (row_number, user_id, http_status,
+// error_message) for every row or value that failed to resolve, so operators can retry just
+// those rows instead of re-running an entire job. A nil *errorRecorder is a no-op, matching the
+// behaviour when -errors-out isn't supplied. Safe for concurrent use by the worker pool.
+type errorRecorder struct {
+	mu     sync.Mutex
+	writer *csv.Writer
+	file   *os.File
+}
+
+// newErrorRecorder creates the companion CSV at path and writes its header. It returns a nil
+// *errorRecorder, nil error when path is empty, so callers can treat "-errors-out not supplied"
+// and "recorder" uniformly via errorRecorder's nil-receiver methods.
+func newErrorRecorder(path string) (*errorRecorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"row_number", "user_id", "http_status", "error_message"}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer.Flush()
+	return &errorRecorder{writer: writer, file: file}, nil
+}
+
+// record appends a single failure to the companion CSV. key is the resolved ID, username, email,
+// channel ID or team ID involved - the column is always called "user_id" to match the schema this
+// request specified regardless of entity kind.
+func (r *errorRecorder) record(rowNumber int, key string, lookupErr *lookupError) {
+	if r == nil || lookupErr == nil {
+		return
+	}
+	status := ""
+	if lookupErr.statusCode != 0 {
+		status = strconv.Itoa(lookupErr.statusCode)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writer.Write([]string{strconv.Itoa(rowNumber), key, status, lookupErr.message})
+	r.writer.Flush()
+}
+
+func (r *errorRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.writer.Flush()
+	r.file.Close()
+}
+
+// runStats accumulates the counters surfaced in each subcommand's final JSON summary. Fields are
+// updated atomically since the worker pool writes to them concurrently.
+type runStats struct {
+	recordsRead    int64
+	recordsWritten int64
+	recordsSkipped int64
+	cacheHits      int64
+	apiCalls       int64
+}
+
+func (s *runStats) hitCache() {
+	if s != nil {
+		atomic.AddInt64(&s.cacheHits, 1)
+	}
+}
+
+func (s *runStats) callAPI() {
+	if s != nil {
+		atomic.AddInt64(&s.apiCalls, 1)
+	}
+}
+
+// summary renders the counters this request asks every subcommand to emit to stdout on completion:
+// records_read, records_written, records_skipped, cache_hits, api_calls and elapsed_ms.
+func (s *runStats) summary(elapsed time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"records_read":    atomic.LoadInt64(&s.recordsRead),
+		"records_written": atomic.LoadInt64(&s.recordsWritten),
+		"records_skipped": atomic.LoadInt64(&s.recordsSkipped),
+		"cache_hits":      atomic.LoadInt64(&s.cacheHits),
+		"api_calls":       atomic.LoadInt64(&s.apiCalls),
+		"elapsed_ms":      elapsed.Milliseconds(),
+	}
+}
+
+// printSummary writes the final JSON summary to stdout for pipeline integration.
+func printSummary(stats *runStats, elapsed time.Duration) {
+	encoded, err := json.Marshal(stats.summary(elapsed))
+	if err != nil {
+		LogMessage(warningLevel, "Unable to encode run summary: "+err.Error())
+		return
+	}
+	fmt.Println(string(encoded))
+}
+
 // Logging functions
+//
+// LogMessage is the single entry point every log line in this file goes through. It is backed by
+// log/slog behind a small interface (appLogger) so the backing implementation, format and level
+// threshold can be swapped via -log-format/-log-level without touching any of LogMessage's many
+// call sites.
 
-// LogMessage logs a formatted message to stdout or stderr
-func LogMessage(level LogLevel, message string) {
+// appLogger is the small interface LogMessage delegates to, keeping the rest of the file
+// ignorant of log/slog's API.
+type appLogger interface {
+	Log(level LogLevel, message string)
+}
+
+// slogLogger adapts log/slog to appLogger, routing ERROR-level messages to stderr and everything
+// else to stdout, matching this tool's historical stream split.
+type slogLogger struct {
+	stdout *slog.Logger
+	stderr *slog.Logger
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case debugLevel:
+		return slog.LevelDebug
+	case warningLevel:
+		return slog.LevelWarn
+	case errorLevel:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (l *slogLogger) Log(level LogLevel, message string) {
+	target := l.stdout
 	if level == errorLevel {
-		log.SetOutput(os.Stderr)
-	} else {
-		log.SetOutput(os.Stdout)
+		target = l.stderr
+	}
+	target.Log(context.Background(), slogLevel(level), message)
+}
+
+// logLevelThreshold is shared by both handlers created in configureLogging, so -log-level takes
+// effect immediately even though the handlers themselves are rebuilt at startup.
+var logLevelThreshold = new(slog.LevelVar)
+
+// logger is the appLogger every LogMessage call delegates to. It defaults to a text logger at
+// info level so messages are sensible even before configureLogging runs (e.g. during flag
+// parsing errors).
+var logger appLogger = newSlogLogger(logFormatText)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+func newSlogLogger(format string) *slogLogger {
+	opts := &slog.HandlerOptions{Level: logLevelThreshold}
+	if format == logFormatJSON {
+		return &slogLogger{
+			stdout: slog.New(slog.NewJSONHandler(os.Stdout, opts)),
+			stderr: slog.New(slog.NewJSONHandler(os.Stderr, opts)),
+		}
+	}
+	return &slogLogger{
+		stdout: slog.New(slog.NewTextHandler(os.Stdout, opts)),
+		stderr: slog.New(slog.NewTextHandler(os.Stderr, opts)),
+	}
+}
+
+// configureLogging rebuilds the package logger for the requested format and level, and is called
+// once from each subcommand after its flags have been parsed. An unrecognised level falls back to
+// "info" (or "debug" when -debug was also supplied), with a warning logged under the fallback.
+func configureLogging(format string, level string, debug bool) {
+	if format != logFormatJSON {
+		format = logFormatText
+	}
+
+	resolvedLevel := strings.ToLower(strings.TrimSpace(level))
+	if resolvedLevel == "" {
+		if debug {
+			resolvedLevel = "debug"
+		} else {
+			resolvedLevel = "info"
+		}
+	}
+
+	switch resolvedLevel {
+	case "debug":
+		logLevelThreshold.Set(slog.LevelDebug)
+	case "info":
+		logLevelThreshold.Set(slog.LevelInfo)
+	case "warning", "warn":
+		logLevelThreshold.Set(slog.LevelWarn)
+	case "error":
+		logLevelThreshold.Set(slog.LevelError)
+	default:
+		logLevelThreshold.Set(slog.LevelInfo)
+		logger = newSlogLogger(format)
+		LogMessage(warningLevel, "Unrecognised -log-level '"+level+"' - defaulting to 'info'")
+		return
 	}
-	log.SetFlags(log.Ldate | log.Ltime)
-	log.Printf("[%s] %s\n", level, message)
+
+	logger = newSlogLogger(format)
+}
+
+// LogMessage logs a formatted message via the configured appLogger.
+func LogMessage(level LogLevel, message string) {
+	logger.Log(level, message)
 }
 
 // DebugPrint allows us to add debug messages into our code, which are only printed if we're running in debug more.
@@ -61,258 +304,2158 @@ func DebugPrint(message string) {
 	if debugMode {
 		LogMessage(debugLevel, message)
 	}
-}
+}
+
+// getEnvWithDefaults allows us to retrieve Environment variables, and to return either the current value or a supplied default
+func getEnvWithDefault(key string, defaultValue interface{}) interface{} {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	return value
+}
+
+// findStringInSlice searches for a string in a slice and returns its index.
+// If the string is not found, it returns -1.
+func findStringInSlice(slice []string, value string) int {
+	for i, item := range slice {
+		if item == value {
+			return i
+		}
+	}
+	return -1 // Not found
+}
+
+// normalizeHeader folds a header name down to a form that can be compared case- and
+// whitespace-insensitively, so "User ID", "user_id " and "USER ID" are all treated as the same
+// column.
+func normalizeHeader(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// findColumnIndex locates columnName within header, comparing names via normalizeHeader so minor
+// formatting differences between exports don't cause a missed match.
+func findColumnIndex(header []string, columnName string) int {
+	normalized := make([]string, len(header))
+	for i, name := range header {
+		normalized[i] = normalizeHeader(name)
+	}
+	return findStringInSlice(normalized, normalizeHeader(columnName))
+}
+
+const (
+	formatCSV   = "csv"
+	formatTSV   = "tsv"
+	formatJSONL = "jsonl"
+	formatXLSX  = "xlsx"
+)
+
+// detectFormat infers a record format from a filename's extension, defaulting to CSV when the
+// extension is missing or unrecognised.
+func detectFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".tsv":
+		return formatTSV
+	case ".jsonl", ".ndjson":
+		return formatJSONL
+	case ".xlsx":
+		return formatXLSX
+	default:
+		return formatCSV
+	}
+}
+
+// RecordCodec abstracts reading and writing tabular records, so processCSVFile can work across
+// CSV, TSV, JSON-lines and XLSX files without caring about the underlying format. Records
+// (including the header) are represented as a plain []string, keeping column lookups such as
+// findColumnIndex format-agnostic.
+type RecordCodec interface {
+	ReadHeader() ([]string, error)
+	ReadRecord() ([]string, error)
+	WriteHeader(header []string) error
+	WriteRecord(record []string) error
+	Flush() error
+}
+
+// delimitedCodec implements RecordCodec for comma- or tab-separated files.
+type delimitedCodec struct {
+	reader *csv.Reader
+	writer *csv.Writer
+}
+
+func newDelimitedCodec(r io.Reader, w io.Writer, delimiter rune) *delimitedCodec {
+	codec := &delimitedCodec{}
+	if r != nil {
+		codec.reader = csv.NewReader(r)
+		codec.reader.Comma = delimiter
+	}
+	if w != nil {
+		codec.writer = csv.NewWriter(w)
+		codec.writer.Comma = delimiter
+	}
+	return codec
+}
+
+func (c *delimitedCodec) ReadHeader() ([]string, error) { return c.reader.Read() }
+func (c *delimitedCodec) ReadRecord() ([]string, error) { return c.reader.Read() }
+
+func (c *delimitedCodec) WriteHeader(header []string) error { return c.writer.Write(header) }
+func (c *delimitedCodec) WriteRecord(record []string) error { return c.writer.Write(record) }
+
+func (c *delimitedCodec) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// jsonlCodec implements RecordCodec for JSON-lines files, where each line is a JSON object keyed
+// on column name. The header is inferred from the key order of the first record when reading,
+// and is otherwise just used to order the fields of each written object.
+type jsonlCodec struct {
+	scanner *bufio.Scanner
+	header  []string
+	pending []string
+	writer  io.Writer
+}
+
+func newJSONLCodec(r io.Reader, w io.Writer) *jsonlCodec {
+	codec := &jsonlCodec{writer: w}
+	if r != nil {
+		codec.scanner = bufio.NewScanner(r)
+		codec.scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	}
+	return codec
+}
+
+func (c *jsonlCodec) ReadHeader() ([]string, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	line := c.scanner.Bytes()
+	var header []string
+	var values []string
+	err := jsonparser.ObjectEach(line, func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		header = append(header, string(key))
+		values = append(values, string(value))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse JSON-lines header record: %w", err)
+	}
+	c.header = header
+	c.pending = values
+	return header, nil
+}
+
+func (c *jsonlCodec) ReadRecord() ([]string, error) {
+	if c.pending != nil {
+		record := c.pending
+		c.pending = nil
+		return record, nil
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	line := c.scanner.Bytes()
+	record := make([]string, len(c.header))
+	for i, key := range c.header {
+		value, err := jsonparser.GetString(line, key)
+		if err != nil {
+			value = ""
+		}
+		record[i] = value
+	}
+	return record, nil
+}
+
+func (c *jsonlCodec) WriteHeader(header []string) error {
+	c.header = header
+	return nil
+}
+
+// WriteRecord writes record as a single-line JSON object keyed on c.header. The object is built
+// by hand rather than via json.Marshal(map[string]string{...}), since Go always serializes map
+// keys in sorted order - that would silently reorder every written object's fields away from the
+// header order every other codec preserves.
+func (c *jsonlCodec) WriteRecord(record []string) error {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range c.header {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyJSON, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		value := ""
+		if i < len(record) {
+			value = record[i]
+		}
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(valueJSON)
+	}
+	buf.WriteByte('}')
+	buf.WriteByte('\n')
+	_, err := c.writer.Write(buf.Bytes())
+	return err
+}
+
+func (c *jsonlCodec) Flush() error { return nil }
+
+// xlsxCodec implements RecordCodec for XLSX files via excelize. Unlike the delimited and
+// JSON-lines codecs it isn't stream-based: reads load the whole sheet up front, and writes
+// accumulate into an in-memory workbook that's only saved to disk on Flush.
+type xlsxCodec struct {
+	file      *excelize.File
+	sheet     string
+	rows      [][]string
+	rowIndex  int
+	headerLen int
+	writeRow  int
+	outPath   string
+}
+
+func newXLSXReaderCodec(path string) (*xlsxCodec, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open XLSX file: %w", err)
+	}
+	sheet := f.GetSheetName(0)
+	rows, err := f.GetRows(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read XLSX sheet: %w", err)
+	}
+	return &xlsxCodec{rows: rows}, nil
+}
+
+func newXLSXWriterCodec(path string) *xlsxCodec {
+	f := excelize.NewFile()
+	return &xlsxCodec{file: f, sheet: "Sheet1", outPath: path}
+}
+
+func (c *xlsxCodec) ReadHeader() ([]string, error) {
+	if len(c.rows) == 0 {
+		return nil, io.EOF
+	}
+	c.rowIndex = 1
+	c.headerLen = len(c.rows[0])
+	return c.rows[0], nil
+}
+
+// ReadRecord returns the next data row, padded with empty strings to the header's column count.
+// excelize.GetRows trims trailing blank cells from each row, so a row with a blank value in its
+// last column(s) would otherwise come back shorter than the header, and every caller that indexes
+// a record by column position would panic on it.
+func (c *xlsxCodec) ReadRecord() ([]string, error) {
+	if c.rowIndex >= len(c.rows) {
+		return nil, io.EOF
+	}
+	record := c.rows[c.rowIndex]
+	c.rowIndex++
+	if len(record) < c.headerLen {
+		padded := make([]string, c.headerLen)
+		copy(padded, record)
+		record = padded
+	}
+	return record, nil
+}
+
+func (c *xlsxCodec) WriteHeader(header []string) error { return c.writeRowAt(header) }
+func (c *xlsxCodec) WriteRecord(record []string) error { return c.writeRowAt(record) }
+
+func (c *xlsxCodec) writeRowAt(record []string) error {
+	cellRow := c.writeRow + 1
+	for col, value := range record {
+		cellName, err := excelize.CoordinatesToCellName(col+1, cellRow)
+		if err != nil {
+			return err
+		}
+		if err := c.file.SetCellValue(c.sheet, cellName, value); err != nil {
+			return err
+		}
+	}
+	c.writeRow++
+	return nil
+}
+
+func (c *xlsxCodec) Flush() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.SaveAs(c.outPath)
+}
+
+// openReaderCodec opens path and returns a RecordCodec for the given format along with the
+// underlying file handle (nil for formats such as XLSX that don't read via io.Reader), which the
+// caller is responsible for closing.
+func openReaderCodec(format string, path string) (RecordCodec, *os.File, error) {
+	if format == formatXLSX {
+		codec, err := newXLSXReaderCodec(path)
+		return codec, nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch format {
+	case formatTSV:
+		return newDelimitedCodec(file, nil, '\t'), file, nil
+	case formatJSONL:
+		return newJSONLCodec(file, nil), file, nil
+	default:
+		return newDelimitedCodec(file, nil, ','), file, nil
+	}
+}
+
+// openWriterCodec creates path and returns a RecordCodec for the given format along with the
+// underlying file handle (nil for formats such as XLSX that only write on Flush), which the
+// caller is responsible for closing.
+func openWriterCodec(format string, path string) (RecordCodec, *os.File, error) {
+	if format == formatXLSX {
+		return newXLSXWriterCodec(path), nil, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch format {
+	case formatTSV:
+		return newDelimitedCodec(nil, file, '\t'), file, nil
+	case formatJSONL:
+		return newJSONLCodec(nil, file), file, nil
+	default:
+		return newDelimitedCodec(nil, file, ','), file, nil
+	}
+}
+
+// mattermostRequest performs a single authenticated GET against the Mattermost API, honoring
+// the supplied rate limiter and retrying transient failures (HTTP 429 and 5xx) with exponential
+// backoff and jitter. A 429 response's Retry-After header, if present, takes priority over the
+// computed backoff delay.
+// apiError carries the HTTP status code behind a failed Mattermost API call, so callers building
+// the -errors-out companion CSV can report exactly what the server said about a given row.
+type apiError struct {
+	statusCode int
+	message    string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+// lookupError is what a single-entity resolver (user, channel or team) returns on failure,
+// carrying enough detail for the -errors-out companion CSV without aborting the whole job.
+type lookupError struct {
+	statusCode int
+	message    string
+}
+
+// classifyError turns a plain error from mattermostRequest/mattermostPostRequest into a
+// lookupError, recovering the HTTP status code when the underlying failure was an apiError.
+func classifyError(err error) *lookupError {
+	if err == nil {
+		return nil
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return &lookupError{statusCode: apiErr.statusCode, message: apiErr.message}
+	}
+	return &lookupError{message: err.Error()}
+}
+
+func mattermostRequest(ctx context.Context, mattermostCon mmConnection, limiter *rate.Limiter, url string) ([]byte, error) {
+	return mattermostHTTPRequest(ctx, mattermostCon, limiter, "GET", url, nil)
+}
+
+// mattermostPostRequest performs a single authenticated JSON POST against the Mattermost API,
+// applying the same rate limiting and retry behaviour as mattermostRequest.
+func mattermostPostRequest(ctx context.Context, mattermostCon mmConnection, limiter *rate.Limiter, url string, body []byte) ([]byte, error) {
+	return mattermostHTTPRequest(ctx, mattermostCon, limiter, "POST", url, body)
+}
+
+// mattermostHTTPRequest is the shared implementation behind mattermostRequest and
+// mattermostPostRequest - see mattermostRequest for the retry/backoff behaviour.
+func mattermostHTTPRequest(ctx context.Context, mattermostCon mmConnection, limiter *rate.Limiter, method string, url string, body []byte) ([]byte, error) {
+	backoff := initialBackoff
+
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, url, bodyReader)
+		if err != nil {
+			return nil, fmt.Errorf("error preparing %s: %w", method, err)
+		}
+		req.Header.Add("Authorization", "Bearer "+mattermostCon.mmToken)
+		if body != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			if attempt >= maxRetries {
+				return nil, fmt.Errorf("failed to query Mattermost: %w", err)
+			}
+			DebugPrint(fmt.Sprintf("Request error (attempt %d/%d): %s - retrying after %s", attempt+1, maxRetries, err.Error(), backoff))
+			sleepWithJitter(backoff)
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return nil, fmt.Errorf("unable to read response body: %w", readErr)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			if attempt >= maxRetries {
+				return nil, &apiError{statusCode: resp.StatusCode, message: fmt.Sprintf("giving up after %d attempts, last status: %d", attempt+1, resp.StatusCode)}
+			}
+			delay := backoff
+			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+				if seconds, err := strconv.Atoi(retryAfter); err == nil {
+					delay = time.Duration(seconds) * time.Second
+				}
+			}
+			DebugPrint(fmt.Sprintf("Mattermost returned HTTP %d (attempt %d/%d) - retrying after %s", resp.StatusCode, attempt+1, maxRetries, delay))
+			sleepWithJitter(delay)
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, &apiError{statusCode: resp.StatusCode, message: fmt.Sprintf("unexpected HTTP status %d from Mattermost", resp.StatusCode)}
+		}
+
+		return body, nil
+	}
+}
+
+// nextBackoff doubles the supplied delay, capped at maxBackoff.
+func nextBackoff(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// sleepWithJitter sleeps for delay plus up to 20% additional random jitter, so that a batch of
+// workers backing off together don't all retry in lockstep.
+func sleepWithJitter(delay time.Duration) {
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	time.Sleep(delay + jitter)
+}
+
+// cachedUser holds the subset of the Mattermost user record that this tool needs.
+type cachedUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	Position  string `json:"position"`
+	// TeamMemberships holds the display names of the teams this user belongs to. Unlike the
+	// other fields, it isn't returned by the bulk/by-ID user lookups, so it's left empty until
+	// withTeamMemberships fetches it for a row that actually asks for the "team_memberships"
+	// -emit field.
+	TeamMemberships []string `json:"team_memberships,omitempty"`
+}
+
+// cachedChannel holds the subset of the Mattermost channel record that this tool needs.
+type cachedChannel struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	TeamID      string `json:"team_id"`
+}
+
+// cachedTeam holds the subset of the Mattermost team record that this tool needs.
+type cachedTeam struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// renderUserData picks the username or "Firstname Lastname" form of a user record, mirroring
+// the original in-place substitution behaviour: if no full name is available, the username is
+// used instead even when fullnameFlag is set.
+func renderUserData(username, firstName, lastName string, fullnameFlag bool) string {
+	if !fullnameFlag {
+		return username
+	}
+	fullName := fmt.Sprintf("%s %s", firstName, lastName)
+	if fullName == " " {
+		return username
+	}
+	return fullName
+}
+
+// cacheEntry pairs a cached value with the time it was stored, so cache[V] can enforce a TTL
+// without every entity type needing its own timestamp field.
+type cacheEntry[V any] struct {
+	Value     V         `json:"value"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// cache is a process-local cache of resolved Mattermost entities (users, channels, teams, ...)
+// keyed on an arbitrary string (an ID, or a "kind:key" pair for reverse lookups), with an
+// optional on-disk JSON backing file so repeat runs against the same export don't need to
+// re-resolve entries already seen. It's safe for concurrent use by the worker pools in
+// processUserRecords, processChannelRecords and processTeamRecords.
+type cache[V any] struct {
+	entries sync.Map // key -> cacheEntry[V]
+	path    string
+	ttl     time.Duration
+}
+
+// newCache creates a cache backed by the given file path (empty disables on-disk persistence)
+// with the given TTL (zero means entries never expire).
+func newCache[V any](path string, ttl time.Duration) *cache[V] {
+	return &cache[V]{path: path, ttl: ttl}
+}
+
+// load populates the cache from its backing file, if one is configured and exists.
+func (c *cache[V]) load() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var entries map[string]cacheEntry[V]
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for key, entry := range entries {
+		c.entries.Store(key, entry)
+	}
+	return nil
+}
+
+// save writes the current contents of the cache to its backing file, if one is configured.
+func (c *cache[V]) save() error {
+	if c.path == "" {
+		return nil
+	}
+	entries := make(map[string]cacheEntry[V])
+	c.entries.Range(func(key, value interface{}) bool {
+		entries[key.(string)] = value.(cacheEntry[V])
+		return true
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// get returns the cached value for key, provided an entry exists and hasn't expired under the
+// cache's TTL.
+func (c *cache[V]) get(key string) (V, bool) {
+	value, ok := c.entries.Load(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	entry := value.(cacheEntry[V])
+	if c.ttl > 0 && time.Since(entry.FetchedAt) > c.ttl {
+		var zero V
+		return zero, false
+	}
+	return entry.Value, true
+}
+
+// set stores value under key, stamping it with the current time for TTL purposes.
+func (c *cache[V]) set(key string, value V) {
+	c.entries.Store(key, cacheEntry[V]{Value: value, FetchedAt: time.Now()})
+}
+
+// userCache, channelCache and teamCache are the concrete cache instantiations used by this tool's
+// subcommands.
+type userCache = cache[cachedUser]
+type channelCache = cache[cachedChannel]
+type teamCache = cache[cachedTeam]
+
+// teamMembershipCache caches the team display names a user belongs to, keyed by user ID. It's
+// kept separate from userCache since team memberships aren't part of the bulk/by-ID user lookups
+// and are only ever fetched for the "users" subcommand when the "team_memberships" -emit field is
+// in use.
+type teamMembershipCache = cache[[]string]
+
+func newUserCache(path string, ttl time.Duration) *userCache { return newCache[cachedUser](path, ttl) }
+func newChannelCache(path string, ttl time.Duration) *channelCache {
+	return newCache[cachedChannel](path, ttl)
+}
+func newTeamCache(path string, ttl time.Duration) *teamCache { return newCache[cachedTeam](path, ttl) }
+func newTeamMembershipCache(path string, ttl time.Duration) *teamMembershipCache {
+	return newCache[[]string](path, ttl)
+}
+
+// getUsersFromMattermostBatch resolves userIDs using Mattermost's bulk POST /api/v4/users/ids
+// endpoint, which accepts up to 100 IDs per call. IDs that Mattermost doesn't recognise are
+// simply absent from the returned map.
+func getUsersFromMattermostBatch(mattermostCon mmConnection, limiter *rate.Limiter, userIDs []string) (map[string]cachedUser, error) {
+	const batchSize = 100
+	resolved := make(map[string]cachedUser)
+
+	for start := 0; start < len(userIDs); start += batchSize {
+		end := start + batchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		chunk := userIDs[start:end]
+		DebugPrint(fmt.Sprintf("Resolving batch of %d user ID(s) via /api/v4/users/ids", len(chunk)))
+
+		requestBody, err := json.Marshal(chunk)
+		if err != nil {
+			return resolved, fmt.Errorf("unable to encode batch user ID request: %w", err)
+		}
+
+		url := fmt.Sprintf("%s://%s:%s/api/v4/users/ids", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort)
+		responseBody, err := mattermostPostRequest(context.Background(), mattermostCon, limiter, url, requestBody)
+		if err != nil {
+			return resolved, fmt.Errorf("batch user lookup failed: %w", err)
+		}
+
+		var users []cachedUser
+		if err := json.Unmarshal(responseBody, &users); err != nil {
+			return resolved, fmt.Errorf("unable to decode batch user lookup response: %w", err)
+		}
+		for _, user := range users {
+			resolved[user.ID] = user
+		}
+	}
+
+	return resolved, nil
+}
+
+const (
+	reverseLookupUsername = "username"
+	reverseLookupEmail    = "email"
+)
+
+// getUsersFromMattermostBatchByKeys resolves usernames or emails in bulk via kind's batch
+// endpoint ("/api/v4/users/usernames" or "/api/v4/users/emails"), which - like the ID-based batch
+// endpoint - accepts up to 100 keys per call. The returned map is keyed on the same usernames or
+// emails that were requested.
+func getUsersFromMattermostBatchByKeys(mattermostCon mmConnection, limiter *rate.Limiter, kind string, keys []string) (map[string]cachedUser, error) {
+	const batchSize = 100
+	endpoint := "usernames"
+	if kind == reverseLookupEmail {
+		endpoint = "emails"
+	}
+	resolved := make(map[string]cachedUser)
+
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunk := keys[start:end]
+		DebugPrint(fmt.Sprintf("Resolving batch of %d %s(s) via /api/v4/users/%s", len(chunk), kind, endpoint))
+
+		requestBody, err := json.Marshal(chunk)
+		if err != nil {
+			return resolved, fmt.Errorf("unable to encode batch %s request: %w", kind, err)
+		}
+
+		url := fmt.Sprintf("%s://%s:%s/api/v4/users/%s", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, endpoint)
+		responseBody, err := mattermostPostRequest(context.Background(), mattermostCon, limiter, url, requestBody)
+		if err != nil {
+			return resolved, fmt.Errorf("batch %s lookup failed: %w", kind, err)
+		}
+
+		var users []cachedUser
+		if err := json.Unmarshal(responseBody, &users); err != nil {
+			return resolved, fmt.Errorf("unable to decode batch %s lookup response: %w", kind, err)
+		}
+		for _, user := range users {
+			key := user.Username
+			if kind == reverseLookupEmail {
+				key = user.Email
+			}
+			resolved[key] = user
+		}
+	}
+
+	return resolved, nil
+}
+
+// getUserByUsernameFromMattermost resolves a single user by username via GET
+// /api/v4/users/username/{username}, caching the result under "username:<username>".
+func getUserByUsernameFromMattermost(mattermostCon mmConnection, limiter *rate.Limiter, cache *userCache, username string) (cachedUser, *lookupError) {
+	url := fmt.Sprintf("%s://%s:%s/api/v4/users/username/%s", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, username)
+	return getUserByKeyFromMattermost(mattermostCon, limiter, cache, reverseLookupUsername+":"+username, url)
+}
+
+// getUserByEmailFromMattermost resolves a single user by email via GET /api/v4/users/email/{email},
+// caching the result under "email:<email>".
+func getUserByEmailFromMattermost(mattermostCon mmConnection, limiter *rate.Limiter, cache *userCache, email string) (cachedUser, *lookupError) {
+	url := fmt.Sprintf("%s://%s:%s/api/v4/users/email/%s", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, email)
+	return getUserByKeyFromMattermost(mattermostCon, limiter, cache, reverseLookupEmail+":"+email, url)
+}
+
+// getUserByKeyFromMattermost performs the GET-and-parse work shared by getUserByUsernameFromMattermost
+// and getUserByEmailFromMattermost, storing the resolved user in cache under cacheKey. A failure is
+// returned as a lookupError rather than aborting the run, so a single bad row never kills a batch job.
+func getUserByKeyFromMattermost(mattermostCon mmConnection, limiter *rate.Limiter, cache *userCache, cacheKey string, url string) (cachedUser, *lookupError) {
+	DebugPrint("Retrieving user data from Mattermost for: " + cacheKey)
+
+	body, err := mattermostRequest(context.Background(), mattermostCon, limiter, url)
+	if err != nil {
+		LogMessage(warningLevel, "Error querying Mattermost for "+cacheKey+": "+err.Error())
+		return cachedUser{}, classifyError(err)
+	}
+
+	var user cachedUser
+	if err := json.Unmarshal(body, &user); err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for "+cacheKey)
+		return cachedUser{}, &lookupError{message: err.Error()}
+	}
+
+	if cache != nil {
+		cache.set(cacheKey, user)
+	}
+	return user, nil
+}
+
+// getChannelsFromMattermostBatch resolves channelIDs via Mattermost's bulk POST
+// /api/v4/channels/ids endpoint, analogous to getUsersFromMattermostBatch.
+func getChannelsFromMattermostBatch(mattermostCon mmConnection, limiter *rate.Limiter, channelIDs []string) (map[string]cachedChannel, error) {
+	const batchSize = 100
+	resolved := make(map[string]cachedChannel)
+
+	var chunkErrors []error
+	for start := 0; start < len(channelIDs); start += batchSize {
+		end := start + batchSize
+		if end > len(channelIDs) {
+			end = len(channelIDs)
+		}
+		chunk := channelIDs[start:end]
+		DebugPrint(fmt.Sprintf("Resolving batch of %d channel ID(s) via /api/v4/channels/ids", len(chunk)))
+
+		requestBody, err := json.Marshal(chunk)
+		if err != nil {
+			// A chunk that can't even be encoded will never succeed on retry - unlike a
+			// transient request failure below - so there's no point going on to later chunks.
+			return resolved, fmt.Errorf("unable to encode batch channel ID request: %w", err)
+		}
+
+		url := fmt.Sprintf("%s://%s:%s/api/v4/channels/ids", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort)
+		responseBody, err := mattermostPostRequest(context.Background(), mattermostCon, limiter, url, requestBody)
+		if err != nil {
+			// Keep going: a single chunk's transient network/5xx error shouldn't cost every
+			// later chunk its chance to resolve in bulk. Channel IDs this chunk would have
+			// resolved fall back to a per-ID GET in the caller.
+			chunkErrors = append(chunkErrors, fmt.Errorf("batch channel lookup failed: %w", err))
+			continue
+		}
+
+		var channels []cachedChannel
+		if err := json.Unmarshal(responseBody, &channels); err != nil {
+			chunkErrors = append(chunkErrors, fmt.Errorf("unable to decode batch channel lookup response: %w", err))
+			continue
+		}
+		for _, channel := range channels {
+			resolved[channel.ID] = channel
+		}
+	}
+
+	return resolved, errors.Join(chunkErrors...)
+}
+
+// getTeamFromMattermost resolves a single team by ID via GET /api/v4/teams/{id}. Mattermost has
+// no bulk-by-ID endpoint for teams, so callers rely on the team cache to avoid repeat lookups for
+// the same team across many channels/rows.
+func getTeamFromMattermost(mattermostCon mmConnection, limiter *rate.Limiter, cache *teamCache, teamID string) (cachedTeam, *lookupError) {
+	if cache != nil {
+		if team, ok := cache.get(teamID); ok {
+			return team, nil
+		}
+	}
+
+	DebugPrint("Retrieving team data from Mattermost for team ID: " + teamID)
+	url := fmt.Sprintf("%s://%s:%s/api/v4/teams/%s", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, teamID)
+	body, err := mattermostRequest(context.Background(), mattermostCon, limiter, url)
+	if err != nil {
+		LogMessage(warningLevel, "Error querying Mattermost for team ID: "+teamID+" ("+err.Error()+")")
+		return cachedTeam{}, classifyError(err)
+	}
+
+	var team cachedTeam
+	if err := json.Unmarshal(body, &team); err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for team ID: "+teamID)
+		return cachedTeam{}, &lookupError{message: err.Error()}
+	}
+
+	if cache != nil {
+		cache.set(teamID, team)
+	}
+	return team, nil
+}
+
+// getChannelFromMattermost resolves a single channel by ID via GET /api/v4/channels/{id}. It's the
+// per-row fallback for a channel ID that getChannelsFromMattermostBatch didn't return - whether
+// because it's genuinely unknown/inaccessible, or because its batch chunk hit a transient error -
+// mirroring how userResolver falls back to a single GET on a bulk-lookup cache miss.
+func getChannelFromMattermost(mattermostCon mmConnection, limiter *rate.Limiter, cache *channelCache, channelID string) (cachedChannel, *lookupError) {
+	DebugPrint("Retrieving channel data from Mattermost for channel ID: " + channelID)
+	url := fmt.Sprintf("%s://%s:%s/api/v4/channels/%s", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, channelID)
+	body, err := mattermostRequest(context.Background(), mattermostCon, limiter, url)
+	if err != nil {
+		LogMessage(warningLevel, "Error querying Mattermost for channel ID: "+channelID+" ("+err.Error()+")")
+		return cachedChannel{}, classifyError(err)
+	}
+
+	var channel cachedChannel
+	if err := json.Unmarshal(body, &channel); err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for channel ID: "+channelID)
+		return cachedChannel{}, &lookupError{message: err.Error()}
+	}
+
+	if cache != nil {
+		cache.set(channelID, channel)
+	}
+	return channel, nil
+}
+
+// getUserTeamMembershipsFromMattermost resolves the display names of every team userID belongs to,
+// via GET /api/v4/users/{user_id}/teams. Used to populate the "team_memberships" -emit field, which
+// - unlike the rest of cachedUser - isn't returned by the bulk user-lookup endpoints, so it's only
+// fetched for rows that actually ask for it.
+func getUserTeamMembershipsFromMattermost(mattermostCon mmConnection, limiter *rate.Limiter, userID string) ([]string, *lookupError) {
+	DebugPrint("Retrieving team memberships from Mattermost for user ID: " + userID)
+
+	url := fmt.Sprintf("%s://%s:%s/api/v4/users/%s/teams", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, userID)
+	body, err := mattermostRequest(context.Background(), mattermostCon, limiter, url)
+	if err != nil {
+		LogMessage(warningLevel, "Error querying Mattermost for team memberships of user ID: "+userID+" ("+err.Error()+")")
+		return nil, classifyError(err)
+	}
+
+	var teams []cachedTeam
+	if err := json.Unmarshal(body, &teams); err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for team memberships of user ID: "+userID)
+		return nil, &lookupError{message: err.Error()}
+	}
+
+	names := make([]string, len(teams))
+	for i, team := range teams {
+		names[i] = team.DisplayName
+	}
+	return names, nil
+}
+
+// getUserFromMattermost resolves a single user by ID via GET /api/v4/users/{id}. Every failure
+// path - a transport/HTTP error, or malformed JSON in the response - is reported as a lookupError
+// rather than aborting the process, since a single bad user ID should never kill a large batch job.
+func getUserFromMattermost(mattermostCon mmConnection, limiter *rate.Limiter, cache *userCache, userID string, fullnameFlag bool) (string, *lookupError) {
+	DebugPrint("Retrieving user data from Mattermost for user ID: " + userID)
+
+	url := fmt.Sprintf("%s://%s:%s/api/v4/users/%s", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, userID)
+	DebugPrint("URL to call: " + url)
+
+	body, err := mattermostRequest(context.Background(), mattermostCon, limiter, url)
+	if err != nil {
+		LogMessage(warningLevel, "Error querying Mattermost for user ID: "+userID+" ("+err.Error()+")")
+		return "", classifyError(err)
+	}
+
+	// Parse the response
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		LogMessage(warningLevel, "Failed to convert body data for user ID: "+userID+": "+err.Error())
+		return "", &lookupError{message: err.Error()}
+	}
+
+	// Convert the data to a string to return to the calling function
+	mmUserData, err := json.Marshal(result)
+	if err != nil {
+		LogMessage(warningLevel, "Unable to convert user data to string for user ID: "+userID+": "+err.Error())
+		return "", &lookupError{message: err.Error()}
+	}
+
+	username, err := jsonparser.GetString([]byte(mmUserData), "username")
+	if err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
+		return "", &lookupError{message: "missing username field in response"}
+	}
+	userEmail, err := jsonparser.GetString([]byte(mmUserData), "email")
+	if err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
+		return "", &lookupError{message: "missing email field in response"}
+	}
+	userFirstName, err := jsonparser.GetString([]byte(mmUserData), "first_name")
+	if err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
+		return "", &lookupError{message: "missing first_name field in response"}
+	}
+	userLastName, err := jsonparser.GetString([]byte(mmUserData), "last_name")
+	if err != nil {
+		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
+		return "", &lookupError{message: "missing last_name field in response"}
+	}
+	// Nickname and position are optional on a Mattermost user record, so a missing value isn't
+	// treated as a lookup failure.
+	userNickname, _ := jsonparser.GetString([]byte(mmUserData), "nickname")
+	userPosition, _ := jsonparser.GetString([]byte(mmUserData), "position")
+	DebugPrint("Username: " + username + " Email: " + userEmail + " First name: " + userFirstName + " Last name: " + userLastName)
+
+	if cache != nil {
+		cache.set(userID, cachedUser{
+			ID:        userID,
+			Username:  username,
+			Email:     userEmail,
+			FirstName: userFirstName,
+			LastName:  userLastName,
+			Nickname:  userNickname,
+			Position:  userPosition,
+		})
+	}
+
+	return renderUserData(username, userFirstName, userLastName, fullnameFlag), nil
+}
+
+// columnSpec pairs a selected column's header name with its resolved index, so multiple
+// -column flags can each be resolved independently against the same row.
+type columnSpec struct {
+	name  string
+	index int
+}
+
+// emitField names a piece of resolved user data that can be copied into an additional output
+// column via the -emit flag, rather than overwriting the original ID column in place.
+type emitField string
+
+const (
+	emitUserID   emitField = "user_id"
+	emitUsername emitField = "username"
+	emitEmail    emitField = "email"
+	emitFullName emitField = "full_name"
+	emitNickname emitField = "nickname"
+	emitPosition emitField = "position"
+	emitTeams    emitField = "team_memberships"
+)
+
+// parseEmitFields splits a comma-separated -emit value into its component fields.
+func parseEmitFields(value string) []emitField {
+	if value == "" {
+		return nil
+	}
+	var fields []emitField
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			fields = append(fields, emitField(part))
+		}
+	}
+	return fields
+}
+
+// emitValue returns the piece of user data named by field, or an empty string for an
+// unrecognised field name.
+func emitValue(user cachedUser, field emitField) string {
+	switch field {
+	case emitUserID:
+		return user.ID
+	case emitUsername:
+		return user.Username
+	case emitEmail:
+		return user.Email
+	case emitFullName:
+		return renderUserData(user.Username, user.FirstName, user.LastName, true)
+	case emitNickname:
+		return user.Nickname
+	case emitPosition:
+		return user.Position
+	case emitTeams:
+		return strings.Join(user.TeamMemberships, ",")
+	default:
+		return ""
+	}
+}
+
+// joinEmitValues renders field for each user and joins the results with a comma, used when a
+// source cell contained more than one ID and -expand=join is in effect.
+func joinEmitValues(users []cachedUser, field emitField) string {
+	values := make([]string, len(users))
+	for i, user := range users {
+		values[i] = emitValue(user, field)
+	}
+	return strings.Join(values, ",")
+}
+
+// templateUserData is the shape of a resolved user exposed to a -template string, evaluated with
+// Go's text/template package.
+type templateUserData struct {
+	UserID    string
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	FullName  string
+	Nickname  string
+	Position  string
+	Teams     string
+}
+
+func toTemplateUserData(user cachedUser) templateUserData {
+	return templateUserData{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		FullName:  renderUserData(user.Username, user.FirstName, user.LastName, true),
+		Nickname:  user.Nickname,
+		Position:  user.Position,
+		Teams:     strings.Join(user.TeamMemberships, ","),
+	}
+}
+
+// renderTemplate evaluates tmpl against user, logging and returning an empty string if execution
+// fails rather than aborting the whole row.
+func renderTemplate(tmpl *template.Template, user cachedUser) string {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, toTemplateUserData(user)); err != nil {
+		LogMessage(warningLevel, "Error rendering template for user ID '"+user.ID+"': "+err.Error())
+		return ""
+	}
+	return buf.String()
+}
+
+const (
+	expandJoin = "join"
+	expandRows = "rows"
+)
+
+// cloneRecord returns an independent copy of record, so expansion can branch a row into several
+// without the branches aliasing each other's backing array.
+func cloneRecord(record []string) []string {
+	clone := make([]string, len(record))
+	copy(clone, record)
+	return clone
+}
+
+// splitCellValues splits a cell on commas, trimming surrounding whitespace from each part. A
+// cell with no commas is returned as a single-element slice unchanged.
+func splitCellValues(value string) []string {
+	parts := strings.Split(value, ",")
+	values := make([]string, len(parts))
+	for i, part := range parts {
+		values[i] = strings.TrimSpace(part)
+	}
+	return values
+}
+
+// buildOutputHeader extends header with one "<column>_<field>" column per (column, emit field)
+// pair, plus one "<column>_rendered" column per templated column. When neither -emit nor
+// -template is in use, header is returned unchanged, preserving the original in-place
+// replacement behaviour.
+func buildOutputHeader(header []string, columns []columnSpec, emitFields []emitField, templateSet bool) []string {
+	if len(emitFields) == 0 && !templateSet {
+		return header
+	}
+	extended := cloneRecord(header)
+	for _, col := range columns {
+		for _, field := range emitFields {
+			extended = append(extended, col.name+"_"+string(field))
+		}
+		if templateSet {
+			extended = append(extended, col.name+"_rendered")
+		}
+	}
+	return extended
+}
+
+// expandRecords duplicates each record in records once per user in users, setting the expanding
+// column's value (or its -emit/-template columns) for a single user on each copy. Used when
+// -expand=rows and a source cell contained more than one ID.
+func expandRecords(records [][]string, col columnSpec, users []cachedUser, fullnameFlag bool, emitFields []emitField, tmpl *template.Template) [][]string {
+	appendColumns := len(emitFields) > 0
+	expanded := make([][]string, 0, len(records)*len(users))
+	for _, base := range records {
+		for _, user := range users {
+			rec := cloneRecord(base)
+			if appendColumns {
+				for _, field := range emitFields {
+					rec = append(rec, emitValue(user, field))
+				}
+			} else {
+				rec[col.index] = renderUserData(user.Username, user.FirstName, user.LastName, fullnameFlag)
+			}
+			if tmpl != nil {
+				rec = append(rec, renderTemplate(tmpl, user))
+			}
+			expanded = append(expanded, rec)
+		}
+	}
+	return expanded
+}
+
+// userResolver builds the per-key resolve function used by resolveRecordColumns for the "users"
+// subcommand: a cache hit short-circuits the lookup, otherwise it falls back to a single
+// Mattermost lookup by ID, which also populates the cache for subsequent rows.
+func userResolver(mattermostCon mmConnection, limiter *rate.Limiter, cache *userCache, stats *runStats) func(string) (cachedUser, *lookupError) {
+	return func(userID string) (cachedUser, *lookupError) {
+		if user, ok := cache.get(userID); ok {
+			stats.hitCache()
+			return user, nil
+		}
+		stats.callAPI()
+		if _, lookupErr := getUserFromMattermost(mattermostCon, limiter, cache, userID, false); lookupErr != nil {
+			return cachedUser{}, lookupErr
+		}
+		user, _ := cache.get(userID)
+		return user, nil
+	}
+}
+
+// reverseUserResolver builds the per-key resolve function used by resolveRecordColumns for the
+// "users-reverse" subcommand: a cache hit (keyed on "kind:key") short-circuits the lookup,
+// otherwise it falls back to a single Mattermost lookup by username or email.
+func reverseUserResolver(mattermostCon mmConnection, limiter *rate.Limiter, cache *userCache, kind string, stats *runStats) func(string) (cachedUser, *lookupError) {
+	return func(key string) (cachedUser, *lookupError) {
+		cacheKey := kind + ":" + key
+		if user, ok := cache.get(cacheKey); ok {
+			stats.hitCache()
+			return user, nil
+		}
+		stats.callAPI()
+		if kind == reverseLookupEmail {
+			return getUserByEmailFromMattermost(mattermostCon, limiter, cache, key)
+		}
+		return getUserByUsernameFromMattermost(mattermostCon, limiter, cache, key)
+	}
+}
+
+// withTeamMemberships wraps resolve so every user it returns also carries its team memberships,
+// fetched (and cached by user ID, independently of whatever key resolve itself was keyed on) only
+// once per user. Callers only need this when the "team_memberships" -emit field or template
+// variable is actually in use, since it costs one extra Mattermost call per distinct user.
+func withTeamMemberships(mattermostCon mmConnection, limiter *rate.Limiter, cache *teamMembershipCache, stats *runStats, resolve func(string) (cachedUser, *lookupError)) func(string) (cachedUser, *lookupError) {
+	return func(key string) (cachedUser, *lookupError) {
+		user, lookupErr := resolve(key)
+		if lookupErr != nil {
+			return user, lookupErr
+		}
+		if teams, ok := cache.get(user.ID); ok {
+			user.TeamMemberships = teams
+			return user, nil
+		}
+		stats.callAPI()
+		teams, lookupErr := getUserTeamMembershipsFromMattermost(mattermostCon, limiter, user.ID)
+		if lookupErr != nil {
+			LogMessage(warningLevel, "Unable to resolve team memberships for user ID '"+user.ID+"': "+lookupErr.message)
+			return user, nil
+		}
+		cache.set(user.ID, teams)
+		user.TeamMemberships = teams
+		return user, nil
+	}
+}
+
+// resolveRecordColumns resolves every configured ID/key column in record via resolve. A cell
+// containing a comma-separated list of IDs is expanded per expandMode: "join" resolves every ID
+// and joins the rendered/derived values with a comma in a single row, "rows" instead duplicates
+// the row once per ID. It returns false only when a single, non-emitting column fails to resolve
+// at all, matching the original behaviour of skipping that row entirely. Every per-value failure
+// is also reported to onError (if non-nil) so the caller can record it in the -errors-out CSV.
+func resolveRecordColumns(record []string, columns []columnSpec, resolve func(string) (cachedUser, *lookupError), fullnameFlag bool, emitFields []emitField, tmpl *template.Template, expandMode string, onError func(key string, lookupErr *lookupError)) ([][]string, bool) {
+	records := [][]string{cloneRecord(record)}
+	appendColumns := len(emitFields) > 0
+
+	for _, col := range columns {
+		ids := splitCellValues(record[col.index])
+
+		users := make([]cachedUser, 0, len(ids))
+		for _, id := range ids {
+			user, lookupErr := resolve(id)
+			if lookupErr != nil {
+				LogMessage(warningLevel, "Error looking up User ID '"+id+"' in column '"+col.name+"' - skipping value")
+				if onError != nil {
+					onError(id, lookupErr)
+				}
+				continue
+			}
+			users = append(users, user)
+		}
+		if len(users) == 0 {
+			if !appendColumns && tmpl == nil && len(columns) == 1 {
+				return nil, false
+			}
+			// Even though this column resolved nothing, buildOutputHeader already added its
+			// -emit/-template columns for every row, so we still have to append placeholders
+			// here or every row after a failed column would be short of its header.
+			if appendColumns {
+				for range emitFields {
+					for i := range records {
+						records[i] = append(records[i], "")
+					}
+				}
+			}
+			if tmpl != nil {
+				for i := range records {
+					records[i] = append(records[i], "")
+				}
+			}
+			continue
+		}
+
+		if expandMode == expandRows && len(users) > 1 {
+			records = expandRecords(records, col, users, fullnameFlag, emitFields, tmpl)
+			continue
+		}
+
+		if appendColumns {
+			for _, field := range emitFields {
+				joined := joinEmitValues(users, field)
+				for i := range records {
+					records[i] = append(records[i], joined)
+				}
+			}
+		} else {
+			rendered := make([]string, len(users))
+			for i, user := range users {
+				rendered[i] = renderUserData(user.Username, user.FirstName, user.LastName, fullnameFlag)
+			}
+			joined := strings.Join(rendered, ",")
+			for i := range records {
+				records[i][col.index] = joined
+			}
+		}
+
+		if tmpl != nil {
+			rendered := make([]string, len(users))
+			for i, user := range users {
+				rendered[i] = renderTemplate(tmpl, user)
+			}
+			joined := strings.Join(rendered, ",")
+			for i := range records {
+				records[i] = append(records[i], joined)
+			}
+		}
+	}
+
+	return records, true
+}
+
+// runRowPipeline fans the rows read from reader out across a pool of concurrency workers, each
+// resolving a row via resolveRow, and collects the results back into their original file order
+// before writing them to writer. It's the common shape shared by processUserRecords,
+// processChannelRecords and processTeamRecords, which differ only in what resolveRow does with
+// each row (resolveRow reports row-level failures via onError/stats itself, as resolveRecordColumns
+// and the channel/team lookups already do; it returns ok=false only to mark a row skipped).
+// recordsWritten and recordsSkipped are stamped onto stats; a non-nil readErr or writeErr means
+// the caller should treat the whole run as failed.
+func runRowPipeline(reader RecordCodec, writer RecordCodec, concurrency int, stats *runStats, resolveRow func(job csvJob) (records [][]string, ok bool)) (readErr error, writeErr error) {
+	jobs := make(chan csvJob, concurrency*2)
+	results := make(chan csvResult, concurrency*2)
+
+	var workers sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				records, ok := resolveRow(job)
+				if !ok {
+					results <- csvResult{index: job.index, skipped: true}
+					continue
+				}
+				results <- csvResult{index: job.index, records: records}
+			}
+		}()
+	}
+
+	// The reader goroutine feeds rows into the job channel in file order; each row is tagged
+	// with its sequence number so the collector can restore that order once workers - which may
+	// complete out of order - have finished with it.
+	go func() {
+		defer close(jobs)
+		for rowNumber := 0; ; rowNumber++ {
+			record, err := reader.ReadRecord()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				readErr = fmt.Errorf("unable to process input record: %w", err)
+				return
+			}
+			DebugPrint("Current record: [ " + strings.Join(record, ", ") + " ]")
+			stats.recordsRead++
+			jobs <- csvJob{index: rowNumber, record: record}
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// The collector buffers out-of-order results keyed on row index, and only calls
+	// writer.WriteRecord once the next row in sequence has arrived.
+	pending := make(map[int]csvResult)
+	nextRow := 0
+	recordsProcessed := 0
+	recordsSkipped := 0
+	for result := range results {
+		pending[result.index] = result
+		for {
+			next, ok := pending[nextRow]
+			if !ok {
+				break
+			}
+			delete(pending, nextRow)
+			nextRow++
+			if next.skipped {
+				recordsSkipped++
+				continue
+			}
+			for _, record := range next.records {
+				if err := writer.WriteRecord(record); err != nil && writeErr == nil {
+					writeErr = err
+				}
+				recordsProcessed++
+			}
+		}
+	}
+	stats.recordsWritten = int64(recordsProcessed)
+	stats.recordsSkipped = int64(recordsSkipped)
+
+	return readErr, writeErr
+}
+
+// processUserRecords is the shared pipeline behind both the "users" subcommand (resolving user
+// IDs) and the "users-reverse" subcommand (resolving usernames or emails): lookupKind selects
+// which of these a row's column values are, by choosing the matching batch/single-lookup
+// endpoints and cache key scheme. An empty lookupKind means ID-based lookup.
+func processUserRecords(mattermostCon mmConnection, csvInputFile string, csvOuputFIle string, inputFormat string, outputFormat string, userIDColumns []string, fullnameFlag bool, concurrency int, requestsPerSecond float64, cachePath string, cacheTTL time.Duration, refreshCache bool, emitFields []emitField, tmpl *template.Template, expandMode string, lookupKind string, errorsOutPath string) (ok bool) {
+	DebugPrint("Starting to process CSV file")
+	start := time.Now()
+	stats := &runStats{}
+	// Deferred so the run summary is always printed, even when the function returns early
+	// on a read/write error below.
+	defer func() { printSummary(stats, time.Since(start)) }()
+
+	errRecorder, err := newErrorRecorder(errorsOutPath)
+	if err != nil {
+		LogMessage(warningLevel, "Unable to create -errors-out file "+errorsOutPath+": "+err.Error())
+	}
+	defer errRecorder.close()
+
+	LogMessage(infoLevel, "Processing data from file: "+csvInputFile)
+	LogMessage(infoLevel, "Writing output to file:    "+csvOuputFIle)
+	DebugPrint(fmt.Sprintf("Concurrency: %d, Rate limit: %.2f req/s, Input format: %s, Output format: %s", concurrency, requestsPerSecond, inputFormat, outputFormat))
+
+	reader, inputFile, err := openReaderCodec(inputFormat, csvInputFile)
+	if err != nil {
+		LogMessage(errorLevel, "Error reading input file: "+err.Error())
+		return false
+	}
+
+	// We need to read the header row before starting to process the rest of the file, in order to
+	// identify which entry contains the user ID
+	header, err := reader.ReadHeader()
+	if err != nil {
+		LogMessage(errorLevel, "Unable to read header record from input file: "+err.Error())
+		return false
+	}
+	DebugPrint("Header: " + strings.Join(header, ", "))
+	columns := make([]columnSpec, 0, len(userIDColumns))
+	for _, name := range userIDColumns {
+		index := findColumnIndex(header, name)
+		if index < 0 {
+			LogMessage(errorLevel, "Unable to find column '"+name+"' in header")
+			return false
+		}
+		DebugPrint("Column '" + name + "' is at index: " + strconv.Itoa(index) + " (zero-based)")
+		columns = append(columns, columnSpec{name: name, index: index})
+	}
+
+	cache := newUserCache(cachePath, cacheTTL)
+	if refreshCache {
+		DebugPrint("Refresh flag set - bypassing any existing cache entries")
+	} else if err := cache.load(); err != nil {
+		LogMessage(warningLevel, "Unable to load user cache from "+cachePath+": "+err.Error())
+	}
+	// Deferred so the cache is persisted even when the function returns early on a
+	// read/write error below.
+	defer func() {
+		if err := cache.save(); err != nil {
+			LogMessage(warningLevel, "Unable to persist user cache to "+cachePath+": "+err.Error())
+		}
+	}()
+
+	// cacheKeyFor maps a raw column value to its cache key: IDs are keyed as-is, but usernames and
+	// emails are keyed as "kind:value" so the same cache can't confuse e.g. a username that
+	// happens to match a user ID.
+	cacheKeyFor := func(value string) string {
+		if lookupKind == "" {
+			return value
+		}
+		return lookupKind + ":" + value
+	}
+
+	// Pre-scan every selected ID/key column to collect the set of unique values (splitting any
+	// comma-separated cells), so they can be resolved in bulk via the batch endpoint before we
+	// stream the file through row by row. This turns an N-row job into a handful of batch
+	// lookups plus cache hits, rather than N individual calls.
+	uniqueKeys := make(map[string]struct{})
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			LogMessage(errorLevel, "Unable to process input record: "+err.Error())
+			return false
+		}
+		for _, col := range columns {
+			for _, key := range splitCellValues(record[col.index]) {
+				uniqueKeys[key] = struct{}{}
+			}
+		}
+	}
+	DebugPrint(fmt.Sprintf("Found %d unique value(s) in input file", len(uniqueKeys)))
+
+	var limiter *rate.Limiter
+	if requestsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+	}
+
+	var uncachedKeys []string
+	for key := range uniqueKeys {
+		if refreshCache {
+			uncachedKeys = append(uncachedKeys, key)
+			continue
+		}
+		if _, ok := cache.get(cacheKeyFor(key)); !ok {
+			uncachedKeys = append(uncachedKeys, key)
+		}
+	}
+	if len(uncachedKeys) > 0 {
+		LogMessage(infoLevel, fmt.Sprintf("Resolving %d uncached value(s) in bulk", len(uncachedKeys)))
+		var resolved map[string]cachedUser
+		var err error
+		if lookupKind == "" {
+			resolved, err = getUsersFromMattermostBatch(mattermostCon, limiter, uncachedKeys)
+		} else {
+			resolved, err = getUsersFromMattermostBatchByKeys(mattermostCon, limiter, lookupKind, uncachedKeys)
+		}
+		if err != nil {
+			LogMessage(warningLevel, "Bulk lookup failed, falling back to per-row lookups: "+err.Error())
+		}
+		for key, user := range resolved {
+			cache.set(cacheKeyFor(key), user)
+		}
+	}
+
+	var resolve func(string) (cachedUser, *lookupError)
+	if lookupKind == "" {
+		resolve = userResolver(mattermostCon, limiter, cache, stats)
+	} else {
+		resolve = reverseUserResolver(mattermostCon, limiter, cache, lookupKind, stats)
+	}
+	for _, field := range emitFields {
+		if field == emitTeams {
+			membershipCache := newTeamMembershipCache("", 0)
+			resolve = withTeamMemberships(mattermostCon, limiter, membershipCache, stats, resolve)
+			break
+		}
+	}
+
+	// Re-open the input for a second, streaming pass. Not every codec (XLSX in particular) can
+	// rewind a single open handle, so we simply open it again rather than seeking.
+	if inputFile != nil {
+		inputFile.Close()
+	}
+	reader, inputFile, err = openReaderCodec(inputFormat, csvInputFile)
+	if err != nil {
+		LogMessage(errorLevel, "Unable to re-open input file for second pass: "+err.Error())
+		return false
+	}
+	if inputFile != nil {
+		defer inputFile.Close()
+	}
+	if _, err := reader.ReadHeader(); err != nil {
+		LogMessage(errorLevel, "Unable to re-read header record from input file: "+err.Error())
+		return false
+	}
 
-// getEnvWithDefaults allows us to retrieve Environment variables, and to return either the current value or a supplied default
-func getEnvWithDefault(key string, defaultValue interface{}) interface{} {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		return defaultValue
+	writer, outputFile, err := openWriterCodec(outputFormat, csvOuputFIle)
+	if err != nil {
+		LogMessage(warningLevel, "Unable to create output file - writing to stdout")
+		writer = newDelimitedCodec(nil, os.Stdout, ',')
+		outputFile = nil
+	}
+	if outputFile != nil {
+		defer outputFile.Close()
 	}
-	return value
-}
 
-// findStringInSlice searches for a string in a slice and returns its index.
-// If the string is not found, it returns -1.
-func findStringInSlice(slice []string, value string) int {
-	for i, item := range slice {
-		if item == value {
-			return i
+	// Deferred so a flush failure - the only place xlsxCodec actually writes its workbook to
+	// disk - fails the run instead of being silently swallowed, even when a row-processing error
+	// below has already set ok to false.
+	defer func() {
+		if err := writer.Flush(); err != nil {
+			LogMessage(errorLevel, "Error flushing output file: "+err.Error())
+			ok = false
+		}
+	}()
+
+	// Write out the header row, extended with any -emit/-template columns
+	outputHeader := buildOutputHeader(header, columns, emitFields, tmpl != nil)
+	writer.WriteHeader(outputHeader)
+
+	// At this point, we've read the first line of the input file (the header) and we know at which
+	// position each selected column is located.  We can now process the rest of the file, fanning
+	// lookups out across a worker pool and collecting the results back into their original order.
+	readErr, writeErr := runRowPipeline(reader, writer, concurrency, stats, func(job csvJob) ([][]string, bool) {
+		records, success := resolveRecordColumns(job.record, columns, resolve, fullnameFlag, emitFields, tmpl, expandMode, func(key string, lookupErr *lookupError) {
+			errRecorder.record(job.index+1, key, lookupErr)
+		})
+		if !success {
+			LogMessage(warningLevel, "Error looking up User ID - skipping record!")
+			return nil, false
 		}
+		return records, true
+	})
+
+	if readErr != nil {
+		LogMessage(errorLevel, readErr.Error())
+		return false
 	}
-	return -1 // Not found
-}
 
-func getUserFromMattermost(mattermostCon mmConnection, userID string, fullnameFlag bool) (string, bool) {
-	DebugPrint("Retrieving user data from Mattermost for user ID: " + userID)
+	if writeErr != nil {
+		LogMessage(errorLevel, "Error writing output file: "+writeErr.Error())
+		return false
+	}
+
+	processedRecordsMessage := fmt.Sprintf("Records processed: %d", stats.recordsWritten)
+	LogMessage(infoLevel, processedRecordsMessage)
 
-	userData := ""
+	return true
+}
 
-	url := fmt.Sprintf("%s://%s:%s/api/v4/users/%s", mattermostCon.mmScheme, mattermostCon.mmURL, mattermostCon.mmPort, userID)
-	DebugPrint("URL to call: " + url)
+// processChannelRecords implements the "channels" subcommand: it resolves a single channel-ID
+// column in place to "<team display name>/<channel display name>", bulk-prefetching channels via
+// getChannelsFromMattermostBatch the same way processUserRecords prefetches users, then resolving
+// each channel's team via getTeamFromMattermost (for which Mattermost has no bulk-by-ID
+// endpoint). The team cache is kept in-memory only for the lifetime of the run - it is small and
+// cheaply rebuilt, so unlike the channel cache it isn't persisted via cachePath.
+func processChannelRecords(mattermostCon mmConnection, csvInputFile string, csvOuputFIle string, inputFormat string, outputFormat string, channelColumn string, concurrency int, requestsPerSecond float64, cachePath string, cacheTTL time.Duration, refreshCache bool, errorsOutPath string) (ok bool) {
+	DebugPrint("Starting to process channel CSV file")
+	start := time.Now()
+	stats := &runStats{}
+	// Deferred so the run summary is always printed, even when the function returns early
+	// on a read/write error below.
+	defer func() { printSummary(stats, time.Since(start)) }()
+
+	LogMessage(infoLevel, "Processing data from file: "+csvInputFile)
+	LogMessage(infoLevel, "Writing output to file:    "+csvOuputFIle)
 
-	req, err := http.NewRequest("GET", url, nil)
+	errRecorder, err := newErrorRecorder(errorsOutPath)
 	if err != nil {
-		LogMessage(errorLevel, "Error preparing GET")
-		log.Fatal(err)
+		LogMessage(warningLevel, "Unable to create -errors-out file "+errorsOutPath+": "+err.Error())
 	}
-	// Add the bearer token as a header
-	req.Header.Add("Authorization", "Bearer "+mattermostCon.mmToken)
+	defer errRecorder.close()
 
-	resp, err := http.DefaultClient.Do(req)
+	reader, inputFile, err := openReaderCodec(inputFormat, csvInputFile)
 	if err != nil {
-		LogMessage(errorLevel, "Failed to query Mattermost")
-		log.Fatal(err)
+		LogMessage(errorLevel, "Error reading input file: "+err.Error())
+		return false
 	}
-	defer resp.Body.Close()
 
-	// Extract the body of the message
-	body, err := io.ReadAll(resp.Body)
+	header, err := reader.ReadHeader()
 	if err != nil {
-		LogMessage(errorLevel, "Unable to extract body data from Mqattermost response")
-		log.Fatal(err)
+		LogMessage(errorLevel, "Unable to read header record from input file: "+err.Error())
+		return false
+	}
+	index := findColumnIndex(header, channelColumn)
+	if index < 0 {
+		LogMessage(errorLevel, "Unable to find column '"+channelColumn+"' in header")
+		return false
 	}
+	col := columnSpec{name: channelColumn, index: index}
 
-	// Parse the response
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		LogMessage(errorLevel, "Failed to convert body data")
-		log.Fatal(err)
+	channels := newChannelCache(cachePath, cacheTTL)
+	teams := newTeamCache("", 0)
+	if refreshCache {
+		DebugPrint("Refresh flag set - bypassing any existing cache entries")
+	} else if err := channels.load(); err != nil {
+		LogMessage(warningLevel, "Unable to load channel cache from "+cachePath+": "+err.Error())
 	}
+	// Deferred so the cache is persisted even when the function returns early on a
+	// read/write error below.
+	defer func() {
+		if err := channels.save(); err != nil {
+			LogMessage(warningLevel, "Unable to persist channel cache to "+cachePath+": "+err.Error())
+		}
+	}()
 
-	// Convert the data to a string to return to the calling function
-	mmUserData, err := json.Marshal(result)
-	if err != nil {
-		LogMessage(errorLevel, "Unable to convert user data to string")
-		log.Fatal(err)
+	uniqueIDs := make(map[string]struct{})
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			LogMessage(errorLevel, "Unable to process input record: "+err.Error())
+			return false
+		}
+		uniqueIDs[record[col.index]] = struct{}{}
 	}
+	DebugPrint(fmt.Sprintf("Found %d unique channel ID(s) in input file", len(uniqueIDs)))
 
-	username, err := jsonparser.GetString([]byte(mmUserData), "username")
-	if err != nil {
-		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
-		return "", false
+	var limiter *rate.Limiter
+	if requestsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
 	}
-	userEmail, err := jsonparser.GetString([]byte(mmUserData), "email")
-	if err != nil {
-		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
-		return "", false
+
+	var uncachedIDs []string
+	for channelID := range uniqueIDs {
+		if refreshCache {
+			uncachedIDs = append(uncachedIDs, channelID)
+			continue
+		}
+		if _, ok := channels.get(channelID); !ok {
+			uncachedIDs = append(uncachedIDs, channelID)
+		}
 	}
-	userFirstName, err := jsonparser.GetString([]byte(mmUserData), "first_name")
+	if len(uncachedIDs) > 0 {
+		LogMessage(infoLevel, fmt.Sprintf("Resolving %d uncached channel ID(s) in bulk", len(uncachedIDs)))
+		resolved, err := getChannelsFromMattermostBatch(mattermostCon, limiter, uncachedIDs)
+		if err != nil {
+			LogMessage(warningLevel, "Bulk channel lookup failed, falling back to per-row lookups: "+err.Error())
+		}
+		for channelID, channel := range resolved {
+			channels.set(channelID, channel)
+		}
+	}
+
+	// Re-open the input for a second, streaming pass - see processUserRecords for why this is a
+	// close+reopen rather than a seek.
+	if inputFile != nil {
+		inputFile.Close()
+	}
+	reader, inputFile, err = openReaderCodec(inputFormat, csvInputFile)
 	if err != nil {
-		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
-		return "", false
+		LogMessage(errorLevel, "Unable to re-open input file for second pass: "+err.Error())
+		return false
 	}
-	userLastName, err := jsonparser.GetString([]byte(mmUserData), "last_name")
+	if inputFile != nil {
+		defer inputFile.Close()
+	}
+	if _, err := reader.ReadHeader(); err != nil {
+		LogMessage(errorLevel, "Unable to re-read header record from input file: "+err.Error())
+		return false
+	}
+
+	writer, outputFile, err := openWriterCodec(outputFormat, csvOuputFIle)
 	if err != nil {
-		LogMessage(warningLevel, "Error processing JSON response data for user ID: "+userID)
-		return "", false
+		LogMessage(warningLevel, "Unable to create output file - writing to stdout")
+		writer = newDelimitedCodec(nil, os.Stdout, ',')
+		outputFile = nil
+	}
+	if outputFile != nil {
+		defer outputFile.Close()
 	}
-	userFullName := fmt.Sprintf("%s %s", userFirstName, userLastName)
-	DebugPrint("Username: " + username + " Email: " + userEmail + " Full Name: " + userFullName)
+	// Deferred so a flush failure - the only place xlsxCodec actually writes its workbook to
+	// disk - fails the run instead of being silently swallowed, even when a row-processing error
+	// below has already set ok to false.
+	defer func() {
+		if err := writer.Flush(); err != nil {
+			LogMessage(errorLevel, "Error flushing output file: "+err.Error())
+			ok = false
+		}
+	}()
+
+	writer.WriteHeader(header)
 
-	if fullnameFlag {
-		if userFullName == " " {
-			userData = username
+	readErr, writeErr := runRowPipeline(reader, writer, concurrency, stats, func(job csvJob) ([][]string, bool) {
+		channelID := job.record[col.index]
+		channel, ok := channels.get(channelID)
+		if ok {
+			stats.hitCache()
 		} else {
-			userData = userFullName
+			// Not every channel ID comes back from the bulk lookup - it may be genuinely
+			// unknown, or its batch chunk may have hit a transient error - so fall back to a
+			// single per-ID GET before giving up on the row, the same way userResolver does.
+			stats.callAPI()
+			resolved, lookupErr := getChannelFromMattermost(mattermostCon, limiter, channels, channelID)
+			if lookupErr != nil {
+				LogMessage(warningLevel, "Error looking up channel ID '"+channelID+"' - skipping record!")
+				errRecorder.record(job.index+1, channelID, lookupErr)
+				return nil, false
+			}
+			channel = resolved
 		}
-	} else {
-		userData = username
+		stats.callAPI()
+		team, lookupErr := getTeamFromMattermost(mattermostCon, limiter, teams, channel.TeamID)
+		if lookupErr != nil {
+			LogMessage(warningLevel, "Error looking up team ID '"+channel.TeamID+"' - skipping record!")
+			errRecorder.record(job.index+1, channel.TeamID, lookupErr)
+			return nil, false
+		}
+		record := cloneRecord(job.record)
+		record[col.index] = team.DisplayName + "/" + channel.DisplayName
+		return [][]string{record}, true
+	})
+
+	if readErr != nil {
+		LogMessage(errorLevel, readErr.Error())
+		return false
+	}
+	if writeErr != nil {
+		LogMessage(errorLevel, "Error writing output file: "+writeErr.Error())
+		return false
 	}
 
-	return userData, true
+	LogMessage(infoLevel, fmt.Sprintf("Records processed: %d", stats.recordsWritten))
+
+	return true
 }
 
-func processCSVFile(mattermostCon mmConnection, csvInputFile string, csvOuputFIle string, userIDColumn string, fullnameFlag bool) bool {
-	DebugPrint("Starting to process CSV file")
+// processTeamRecords implements the "teams" subcommand: it resolves a single team-ID column in
+// place to the team's display name. Mattermost has no bulk-by-ID endpoint for teams, so this
+// streams the file in a single pass, resolving each team individually via getTeamFromMattermost
+// (which itself consults the team cache first).
+func processTeamRecords(mattermostCon mmConnection, csvInputFile string, csvOuputFIle string, inputFormat string, outputFormat string, teamColumn string, concurrency int, requestsPerSecond float64, cachePath string, cacheTTL time.Duration, refreshCache bool, errorsOutPath string) (ok bool) {
+	DebugPrint("Starting to process team CSV file")
+	start := time.Now()
+	stats := &runStats{}
+	// Deferred so the run summary is always printed, even when the function returns early
+	// on a read/write error below.
+	defer func() { printSummary(stats, time.Since(start)) }()
 
 	LogMessage(infoLevel, "Processing data from file: "+csvInputFile)
 	LogMessage(infoLevel, "Writing output to file:    "+csvOuputFIle)
 
-	file, err := os.Open(csvInputFile)
+	errRecorder, err := newErrorRecorder(errorsOutPath)
 	if err != nil {
-		log.Fatal("Error reading inpur file", err)
+		LogMessage(warningLevel, "Unable to create -errors-out file "+errorsOutPath+": "+err.Error())
 	}
-	defer file.Close()
+	defer errRecorder.close()
 
-	reader := csv.NewReader(file)
+	reader, inputFile, err := openReaderCodec(inputFormat, csvInputFile)
+	if err != nil {
+		LogMessage(errorLevel, "Error reading input file: "+err.Error())
+		return false
+	}
+	if inputFile != nil {
+		defer inputFile.Close()
+	}
 
-	// We need to read the header row before starting to process the rest of the file, in order to
-	// identify which entry contains the user ID
-	header, err := reader.Read()
+	header, err := reader.ReadHeader()
 	if err != nil {
-		LogMessage(errorLevel, "Unable to read header record from CSV file: "+err.Error())
+		LogMessage(errorLevel, "Unable to read header record from input file: "+err.Error())
 		return false
 	}
-	DebugPrint("CSV Header: " + strings.Join(header, ", "))
-	index := findStringInSlice(header, userIDColumn)
+	index := findColumnIndex(header, teamColumn)
 	if index < 0 {
-		LogMessage(errorLevel, "Unable to find column '"+userIDColumn+"' in CSV header")
+		LogMessage(errorLevel, "Unable to find column '"+teamColumn+"' in header")
 		return false
 	}
-	DebugPrint("Selected column is at index: " + strconv.Itoa(index) + " (zero-based)")
+	col := columnSpec{name: teamColumn, index: index}
+
+	teams := newTeamCache(cachePath, cacheTTL)
+	// Deferred so the cache is persisted even when the function returns early on a
+	// read/write error below.
+	defer func() {
+		if err := teams.save(); err != nil {
+			LogMessage(warningLevel, "Unable to persist team cache to "+cachePath+": "+err.Error())
+		}
+	}()
+	if refreshCache {
+		DebugPrint("Refresh flag set - bypassing any existing cache entries")
+	} else if err := teams.load(); err != nil {
+		LogMessage(warningLevel, "Unable to load team cache from "+cachePath+": "+err.Error())
+	}
+
+	var limiter *rate.Limiter
+	if requestsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+	}
 
-	outfile, err := os.Create(csvOuputFIle)
+	writer, outputFile, err := openWriterCodec(outputFormat, csvOuputFIle)
 	if err != nil {
 		LogMessage(warningLevel, "Unable to create output file - writing to stdout")
-		outfile = os.Stdout
+		writer = newDelimitedCodec(nil, os.Stdout, ',')
+		outputFile = nil
 	}
+	if outputFile != nil {
+		defer outputFile.Close()
+	}
+	// Deferred so a flush failure - the only place xlsxCodec actually writes its workbook to
+	// disk - fails the run instead of being silently swallowed, even when a row-processing error
+	// below has already set ok to false.
+	defer func() {
+		if err := writer.Flush(); err != nil {
+			LogMessage(errorLevel, "Error flushing output file: "+err.Error())
+			ok = false
+		}
+	}()
 
-	// Initialise CSV writer
-	writer := csv.NewWriter(outfile)
+	writer.WriteHeader(header)
 
-	defer writer.Flush()
+	readErr, writeErr := runRowPipeline(reader, writer, concurrency, stats, func(job csvJob) ([][]string, bool) {
+		teamID := job.record[col.index]
+		stats.callAPI()
+		team, lookupErr := getTeamFromMattermost(mattermostCon, limiter, teams, teamID)
+		if lookupErr != nil {
+			LogMessage(warningLevel, "Error looking up team ID '"+teamID+"' - skipping record!")
+			errRecorder.record(job.index+1, teamID, lookupErr)
+			return nil, false
+		}
+		record := cloneRecord(job.record)
+		record[col.index] = team.DisplayName
+		return [][]string{record}, true
+	})
 
-	// Write out the header row
-	writer.Write(header)
+	if readErr != nil {
+		LogMessage(errorLevel, readErr.Error())
+		return false
+	}
+	if writeErr != nil {
+		LogMessage(errorLevel, "Error writing output file: "+writeErr.Error())
+		return false
+	}
 
-	// At this point, we've read the first line of the CSV file (the header) and we know at which
-	// position the user ID column is located.  We can now process the rest of the file.
+	LogMessage(infoLevel, fmt.Sprintf("Records processed: %d", stats.recordsWritten))
 
-	recordsProcessed := 0
+	return true
+}
 
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			LogMessage(errorLevel, "Unable to process CSV record: "+err.Error())
-			return false
-		}
-		DebugPrint("Current record: [ " + strings.Join(record, ", ") + " ]")
-		currentUserID := record[index]
-		DebugPrint("User ID: " + currentUserID)
-		userData, success := getUserFromMattermost(mattermostCon, currentUserID, fullnameFlag)
-		if !success {
-			LogMessage(warningLevel, "Error looking up User ID - skipping record!")
-			continue
-		}
-		DebugPrint("User data from Mattermost: " + userData)
+// commonFlags holds the Mattermost connection, caching, concurrency and record-format flags
+// shared by every subcommand. Each subcommand registers these on its own flag.FlagSet alongside
+// its own flags, then calls resolveCommonFlags once the set has been parsed.
+type commonFlags struct {
+	url         string
+	port        string
+	scheme      string
+	token       string
+	debug       bool
+	concurrency int
+	rate        float64
+	cache       string
+	cacheTTL    string
+	refresh     bool
+	format      string
+	logFormat   string
+	logLevel    string
+	errorsOut   string
+}
+
+// registerCommonFlags binds the shared flags onto fs and returns their destination.
+func registerCommonFlags(fs *flag.FlagSet) *commonFlags {
+	c := &commonFlags{}
+	fs.StringVar(&c.url, "url", "", "The URL of the Mattermost instance (without the HTTP scheme)")
+	fs.StringVar(&c.port, "port", "", "The TCP port used by Mattermost. [Default: "+defaultPort+"]")
+	fs.StringVar(&c.scheme, "scheme", "", "The HTTP scheme to be used (http/https). [Default: "+defaultScheme+"]")
+	fs.StringVar(&c.token, "token", "", "The auth token used to connect to Mattermost")
+	fs.BoolVar(&c.debug, "debug", false, "Enable debug output")
+	fs.IntVar(&c.concurrency, "concurrency", 0, "Number of concurrent Mattermost API lookups to run. [Default: "+strconv.Itoa(defaultConcurrency)+"]")
+	fs.Float64Var(&c.rate, "rate", 0, "Maximum Mattermost API requests per second (0 = unlimited)")
+	fs.StringVar(&c.cache, "cache", "", "Path to an on-disk JSON file used to cache resolved entries between runs (disabled if not set)")
+	fs.StringVar(&c.cacheTTL, "cache-ttl", "0", "How long cached entries remain valid, e.g. '24h' (0 = never expire)")
+	fs.BoolVar(&c.refresh, "refresh", false, "Bypass the cache and re-resolve every entry from Mattermost")
+	fs.StringVar(&c.format, "format", "", "Record format to use for both input and output: csv, tsv, jsonl or xlsx. [Default: autodetect from file extension]")
+	fs.StringVar(&c.logFormat, "log-format", logFormatText, "Log output format: 'text' (default) or 'json'")
+	fs.StringVar(&c.logLevel, "log-level", "", "Minimum log level to emit: debug, info, warning or error. [Default: 'debug' if -debug is set, otherwise 'info']")
+	fs.StringVar(&c.errorsOut, "errors-out", "", "Path to a companion CSV (row_number, user_id, http_status, error_message) capturing every skipped or failed record, for retrying just those rows")
+	return c
+}
 
-		// Now that we have the updated record, we can simply replace the relevant entry in the array
-		record[index] = userData
-		writer.Write(record)
-		recordsProcessed += 1
+// resolvedCommon is the result of applying environment variable fallbacks/defaults to a
+// commonFlags value.
+type resolvedCommon struct {
+	conn        mmConnection
+	concurrency int
+	rate        float64
+	cachePath   string
+	cacheTTL    time.Duration
+	refresh     bool
+	errorsOut   string
+}
+
+// resolveCommonFlags applies environment variable fallbacks and defaults to c, validates the
+// connection fields required by every subcommand, and exits the process if any are missing.
+func resolveCommonFlags(fs *flag.FlagSet, c *commonFlags) resolvedCommon {
+	if c.url == "" {
+		c.url = getEnvWithDefault("MM_URL", "").(string)
+	}
+	if c.port == "" {
+		c.port = getEnvWithDefault("MM_PORT", defaultPort).(string)
+	}
+	if c.scheme == "" {
+		c.scheme = getEnvWithDefault("MM_SCHEME", defaultScheme).(string)
 	}
+	if c.token == "" {
+		c.token = getEnvWithDefault("MM_TOKEN", "").(string)
+	}
+	if !c.debug {
+		c.debug = getEnvWithDefault("MM_DEBUG", debugMode).(bool)
+	}
+	debugMode = c.debug
+	configureLogging(c.logFormat, c.logLevel, c.debug)
 
-	if err := writer.Error(); err != nil {
-		LogMessage(errorLevel, "Error writing CSV file!")
-		log.Fatal(err)
+	if c.concurrency == 0 {
+		if envValue, exists := os.LookupEnv("MM_CONCURRENCY"); exists {
+			if parsed, err := strconv.Atoi(envValue); err == nil {
+				c.concurrency = parsed
+			}
+		}
+	}
+	if c.concurrency <= 0 {
+		c.concurrency = defaultConcurrency
+	}
+	if c.rate == 0 {
+		if envValue, exists := os.LookupEnv("MM_RATE"); exists {
+			if parsed, err := strconv.ParseFloat(envValue, 64); err == nil {
+				c.rate = parsed
+			}
+		}
+	}
+	if c.cache == "" {
+		c.cache = getEnvWithDefault("MM_CACHE_FILE", "").(string)
+	}
+	cacheTTL, err := time.ParseDuration(c.cacheTTL)
+	if err != nil {
+		LogMessage(warningLevel, "Invalid -cache-ttl value '"+c.cacheTTL+"' - caching will never expire")
+		cacheTTL = 0
+	}
+	if c.format == "" {
+		c.format = getEnvWithDefault("MM_FORMAT", "").(string)
 	}
 
-	processedRecordsMessage := fmt.Sprintf("Records processed: %d", recordsProcessed)
-	LogMessage(infoLevel, processedRecordsMessage)
+	DebugPrint("Parameters: MattermostURL=" + c.url + " MattermostPort=" + c.port + " MattermostScheme=" + c.scheme + " MattermostToken=" + c.token)
 
-	return true
+	var cliErrors bool
+	if c.url == "" {
+		LogMessage(errorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
+		cliErrors = true
+	}
+	if c.scheme == "" {
+		LogMessage(errorLevel, "The Mattermost HTTP scheme must be supplied either on the command line of vie the MM_SCHEME environment variable")
+		cliErrors = true
+	}
+	if c.token == "" {
+		LogMessage(errorLevel, "The Mattermost auth token must be supplied either on the command line of vie the MM_TOKEN environment variable")
+		cliErrors = true
+	}
+	if cliErrors {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	return resolvedCommon{
+		conn: mmConnection{
+			mmURL:    c.url,
+			mmPort:   c.port,
+			mmScheme: c.scheme,
+			mmToken:  c.token,
+		},
+		concurrency: c.concurrency,
+		rate:        c.rate,
+		cachePath:   c.cache,
+		cacheTTL:    cacheTTL,
+		refresh:     c.refresh,
+		errorsOut:   c.errorsOut,
+	}
 }
 
-// Main section
+// recordFormats resolves commonFlags.format (falling back to per-file extension detection) into
+// the input and output formats a subcommand should use.
+func recordFormats(format string, infile string, outfile string) (string, string) {
+	inputFormat := format
+	if inputFormat == "" {
+		inputFormat = detectFormat(infile)
+	}
+	outputFormat := format
+	if outputFormat == "" {
+		outputFormat = detectFormat(outfile)
+	}
+	return inputFormat, outputFormat
+}
 
-func main() {
+// parseTemplateFlag parses a non-empty -template value, exiting the process on a parse error.
+func parseTemplateFlag(value string) *template.Template {
+	if value == "" {
+		return nil
+	}
+	tmpl, err := template.New("mm-csv-parser").Parse(value)
+	if err != nil {
+		LogMessage(errorLevel, "Invalid -template value: "+err.Error())
+		os.Exit(1)
+	}
+	return tmpl
+}
 
-	// Parse Command Line
-	DebugPrint("Parsing command line")
+// runUsersCommand implements the "users" subcommand: resolving user IDs to usernames or full
+// names. This is the tool's original behaviour, now reached via "mm-csv-parser users ...".
+func runUsersCommand(args []string) {
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	common := registerCommonFlags(fs)
 
-	var MattermostURL string
-	var MattermostPort string
-	var MattermostScheme string
-	var MattermostToken string
 	var InputCSVFilename string
 	var OutputCSVFilename string
-	var UserIDColumnName string
+	var UserIDColumnNames stringSliceFlag
 	var FullnameFlag bool
-	var DebugFlag bool
+	var EmitFlag string
+	var TemplateFlag string
+	var ExpandFlag string
 
-	flag.StringVar(&MattermostURL, "url", "", "The URL of the Mattermost instance (without the HTTP scheme)")
-	flag.StringVar(&MattermostPort, "port", "", "The TCP port used by Mattermost. [Default: "+defaultPort+"]")
-	flag.StringVar(&MattermostScheme, "scheme", "", "The HTTP scheme to be used (http/https). [Default: "+defaultScheme+"]")
-	flag.StringVar(&MattermostToken, "token", "", "The auth token used to connect to Mattermost")
-	flag.StringVar(&InputCSVFilename, "infile", "", "*Required* The name of the CSV file to be processed")
-	flag.StringVar(&OutputCSVFilename, "outfile", "", "*Required* The name of the output file that the CSV should be written to.")
-	flag.StringVar(&UserIDColumnName, "column", "", "*Required* The name of the column within the CSV file that contains the user ID")
-	flag.BoolVar(&FullnameFlag, "fullname", false, "Return the full name of the Mattermost user, instead of the username (if a full name is available)")
-	flag.BoolVar(&DebugFlag, "debug", false, "Enable debug output")
+	fs.StringVar(&InputCSVFilename, "infile", "", "*Required* The name of the CSV file to be processed")
+	fs.StringVar(&OutputCSVFilename, "outfile", "", "*Required* The name of the output file that the CSV should be written to.")
+	fs.Var(&UserIDColumnNames, "column", "*Required* The name of a column within the CSV file that contains a user ID. May be repeated to resolve several columns.")
+	fs.BoolVar(&FullnameFlag, "fullname", false, "Return the full name of the Mattermost user, instead of the username (if a full name is available)")
+	fs.StringVar(&EmitFlag, "emit", "", "Comma-separated list of fields to append as new columns per -column, instead of replacing it in place: user_id,username,email,full_name,nickname,position,team_memberships")
+	fs.StringVar(&TemplateFlag, "template", "", "A Go text/template string evaluated per resolved user (e.g. '{{.FirstName}} {{.LastName}} <{{.Email}}>'), appended as '<column>_rendered'")
+	fs.StringVar(&ExpandFlag, "expand", expandJoin, "How to handle a cell containing a comma-separated list of IDs: 'join' (default) or 'rows'")
+	fs.Parse(args)
 
-	flag.Parse()
+	resolved := resolveCommonFlags(fs, common)
+	inputFormat, outputFormat := recordFormats(common.format, InputCSVFilename, OutputCSVFilename)
+	emitFields := parseEmitFields(EmitFlag)
+	if ExpandFlag != expandJoin && ExpandFlag != expandRows {
+		LogMessage(warningLevel, "Invalid -expand value '"+ExpandFlag+"' - defaulting to '"+expandJoin+"'")
+		ExpandFlag = expandJoin
+	}
+	tmpl := parseTemplateFlag(TemplateFlag)
 
-	// If parameters have not been passed on the command line, check for the presence of environment variables or defaults.
-	if MattermostURL == "" {
-		MattermostURL = getEnvWithDefault("MM_URL", "").(string)
+	var cliErrors bool
+	if InputCSVFilename == "" {
+		LogMessage(errorLevel, "The CSV input file must be supplied as a command line parameter")
+		cliErrors = true
 	}
-	if MattermostPort == "" {
-		MattermostPort = getEnvWithDefault("MM_PORT", defaultPort).(string)
+	if OutputCSVFilename == "" {
+		LogMessage(errorLevel, "The CSV output file must be supplied as a command line parameter")
+		cliErrors = true
 	}
-	if MattermostScheme == "" {
-		MattermostScheme = getEnvWithDefault("MM_SCHEME", defaultScheme).(string)
+	if len(UserIDColumnNames) == 0 {
+		LogMessage(errorLevel, "At least one user ID column name from the CSV must be supplied as a command line parameter")
+		cliErrors = true
 	}
-	if MattermostToken == "" {
-		MattermostToken = getEnvWithDefault("MM_TOKEN", "").(string)
+	if cliErrors {
+		fs.Usage()
+		os.Exit(1)
 	}
-	if !DebugFlag {
-		DebugFlag = getEnvWithDefault("MM_DEBUG", debugMode).(bool)
+
+	fullnameMode = FullnameFlag
+
+	if !processUserRecords(resolved.conn, InputCSVFilename, OutputCSVFilename, inputFormat, outputFormat, []string(UserIDColumnNames), FullnameFlag, resolved.concurrency, resolved.rate, resolved.cachePath, resolved.cacheTTL, resolved.refresh, emitFields, tmpl, ExpandFlag, "", resolved.errorsOut) {
+		os.Exit(1)
 	}
 
-	DebugPrint("Parameters: MattermostURL=" + MattermostURL + " MattermostPort=" + MattermostPort + " MattermostScheme=" + MattermostScheme + " MattermostToken=" + MattermostToken + " InputCSVFilename=" + InputCSVFilename + " OutputCSVFilename='" + OutputCSVFilename + "' UserIDColumnName='" + UserIDColumnName + "'")
-	if FullnameFlag {
-		DebugPrint("Fullname flag is set")
+	LogMessage(infoLevel, "CSV processing complete!")
+}
+
+// runUsersReverseCommand implements the "users-reverse" subcommand: resolving usernames or
+// emails back to user IDs, for the common workflow of turning a team lead's spreadsheet of
+// usernames into IDs for further API scripting.
+func runUsersReverseCommand(args []string) {
+	fs := flag.NewFlagSet("users-reverse", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+
+	var InputCSVFilename string
+	var OutputCSVFilename string
+	var ColumnNames stringSliceFlag
+	var ColumnTypeFlag string
+	var EmitFlag string
+	var TemplateFlag string
+	var ExpandFlag string
+
+	fs.StringVar(&InputCSVFilename, "infile", "", "*Required* The name of the CSV file to be processed")
+	fs.StringVar(&OutputCSVFilename, "outfile", "", "*Required* The name of the output file that the CSV should be written to.")
+	fs.Var(&ColumnNames, "column", "*Required* The name of a column within the CSV file that contains a username or email. May be repeated to resolve several columns.")
+	fs.StringVar(&ColumnTypeFlag, "column-type", reverseLookupUsername, "What the -column values hold: 'username' (default) or 'email'")
+	fs.StringVar(&EmitFlag, "emit", "", "Comma-separated list of fields to append as new columns per -column, instead of replacing it in place: user_id,username,email,full_name,nickname,position,team_memberships")
+	fs.StringVar(&TemplateFlag, "template", "", "A Go text/template string evaluated per resolved user (e.g. '{{.UserID}}'), appended as '<column>_rendered'")
+	fs.StringVar(&ExpandFlag, "expand", expandJoin, "How to handle a cell containing a comma-separated list of usernames/emails: 'join' (default) or 'rows'")
+	fs.Parse(args)
+
+	resolved := resolveCommonFlags(fs, common)
+	inputFormat, outputFormat := recordFormats(common.format, InputCSVFilename, OutputCSVFilename)
+	emitFields := parseEmitFields(EmitFlag)
+	if ExpandFlag != expandJoin && ExpandFlag != expandRows {
+		LogMessage(warningLevel, "Invalid -expand value '"+ExpandFlag+"' - defaulting to '"+expandJoin+"'")
+		ExpandFlag = expandJoin
+	}
+	tmpl := parseTemplateFlag(TemplateFlag)
+	if ColumnTypeFlag != reverseLookupUsername && ColumnTypeFlag != reverseLookupEmail {
+		LogMessage(errorLevel, "Invalid -column-type value '"+ColumnTypeFlag+"' - must be 'username' or 'email'")
+		os.Exit(1)
 	}
 
-	// Validate required parameters
-	DebugPrint("Validating parameters")
-	var cliErrors bool = false
-	if MattermostURL == "" {
-		LogMessage(errorLevel, "The Mattermost URL must be supplied either on the command line of vie the MM_URL environment variable")
+	var cliErrors bool
+	if InputCSVFilename == "" {
+		LogMessage(errorLevel, "The CSV input file must be supplied as a command line parameter")
 		cliErrors = true
 	}
-	if MattermostScheme == "" {
-		LogMessage(errorLevel, "The Mattermost HTTP scheme must be supplied either on the command line of vie the MM_SCHEME environment variable")
+	if OutputCSVFilename == "" {
+		LogMessage(errorLevel, "The CSV output file must be supplied as a command line parameter")
 		cliErrors = true
 	}
-	if MattermostToken == "" {
-		LogMessage(errorLevel, "The Mattermost auth token must be supplied either on the command line of vie the MM_TOKEN environment variable")
+	if len(ColumnNames) == 0 {
+		LogMessage(errorLevel, "At least one column name from the CSV must be supplied as a command line parameter")
 		cliErrors = true
 	}
+	if cliErrors {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if !processUserRecords(resolved.conn, InputCSVFilename, OutputCSVFilename, inputFormat, outputFormat, []string(ColumnNames), false, resolved.concurrency, resolved.rate, resolved.cachePath, resolved.cacheTTL, resolved.refresh, emitFields, tmpl, ExpandFlag, ColumnTypeFlag, resolved.errorsOut) {
+		os.Exit(1)
+	}
+
+	LogMessage(infoLevel, "CSV processing complete!")
+}
+
+// runChannelsCommand implements the "channels" subcommand: resolving a channel-ID column to
+// "<team display name>/<channel display name>".
+func runChannelsCommand(args []string) {
+	fs := flag.NewFlagSet("channels", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+
+	var InputCSVFilename string
+	var OutputCSVFilename string
+	var ColumnName string
+
+	fs.StringVar(&InputCSVFilename, "infile", "", "*Required* The name of the CSV file to be processed")
+	fs.StringVar(&OutputCSVFilename, "outfile", "", "*Required* The name of the output file that the CSV should be written to.")
+	fs.StringVar(&ColumnName, "column", "", "*Required* The name of the column within the CSV file that contains a channel ID")
+	fs.Parse(args)
+
+	resolved := resolveCommonFlags(fs, common)
+	inputFormat, outputFormat := recordFormats(common.format, InputCSVFilename, OutputCSVFilename)
+
+	var cliErrors bool
 	if InputCSVFilename == "" {
 		LogMessage(errorLevel, "The CSV input file must be supplied as a command line parameter")
 		cliErrors = true
@@ -321,27 +2464,101 @@ func main() {
 		LogMessage(errorLevel, "The CSV output file must be supplied as a command line parameter")
 		cliErrors = true
 	}
-	if UserIDColumnName == "" {
-		LogMessage(errorLevel, "The user ID column name from the CSV must be supplied as a command line parameter")
+	if ColumnName == "" {
+		LogMessage(errorLevel, "The channel ID column name from the CSV must be supplied as a command line parameter")
 		cliErrors = true
 	}
 	if cliErrors {
-		flag.Usage()
+		fs.Usage()
 		os.Exit(1)
 	}
 
-	debugMode = DebugFlag
-	fullnameMode = FullnameFlag
+	if !processChannelRecords(resolved.conn, InputCSVFilename, OutputCSVFilename, inputFormat, outputFormat, ColumnName, resolved.concurrency, resolved.rate, resolved.cachePath, resolved.cacheTTL, resolved.refresh, resolved.errorsOut) {
+		os.Exit(1)
+	}
+
+	LogMessage(infoLevel, "CSV processing complete!")
+}
+
+// runTeamsCommand implements the "teams" subcommand: resolving a team-ID column to the team's
+// display name.
+func runTeamsCommand(args []string) {
+	fs := flag.NewFlagSet("teams", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+
+	var InputCSVFilename string
+	var OutputCSVFilename string
+	var ColumnName string
+
+	fs.StringVar(&InputCSVFilename, "infile", "", "*Required* The name of the CSV file to be processed")
+	fs.StringVar(&OutputCSVFilename, "outfile", "", "*Required* The name of the output file that the CSV should be written to.")
+	fs.StringVar(&ColumnName, "column", "", "*Required* The name of the column within the CSV file that contains a team ID")
+	fs.Parse(args)
 
-	mattermostConenction := mmConnection{
-		mmURL:    MattermostURL,
-		mmPort:   MattermostPort,
-		mmScheme: MattermostScheme,
-		mmToken:  MattermostToken,
+	resolved := resolveCommonFlags(fs, common)
+	inputFormat, outputFormat := recordFormats(common.format, InputCSVFilename, OutputCSVFilename)
+
+	var cliErrors bool
+	if InputCSVFilename == "" {
+		LogMessage(errorLevel, "The CSV input file must be supplied as a command line parameter")
+		cliErrors = true
+	}
+	if OutputCSVFilename == "" {
+		LogMessage(errorLevel, "The CSV output file must be supplied as a command line parameter")
+		cliErrors = true
+	}
+	if ColumnName == "" {
+		LogMessage(errorLevel, "The team ID column name from the CSV must be supplied as a command line parameter")
+		cliErrors = true
+	}
+	if cliErrors {
+		fs.Usage()
+		os.Exit(1)
 	}
 
-	processCSVFile(mattermostConenction, InputCSVFilename, OutputCSVFilename, UserIDColumnName, fullnameMode)
+	if !processTeamRecords(resolved.conn, InputCSVFilename, OutputCSVFilename, inputFormat, outputFormat, ColumnName, resolved.concurrency, resolved.rate, resolved.cachePath, resolved.cacheTTL, resolved.refresh, resolved.errorsOut) {
+		os.Exit(1)
+	}
 
 	LogMessage(infoLevel, "CSV processing complete!")
+}
+
+// printUsage describes the available subcommands; each subcommand's own -h lists its flags.
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: mm-csv-parser <subcommand> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Subcommands:")
+	fmt.Fprintln(os.Stderr, "  users          Resolve user IDs to usernames/full names")
+	fmt.Fprintln(os.Stderr, "  users-reverse  Resolve usernames or emails to user IDs")
+	fmt.Fprintln(os.Stderr, "  channels       Resolve channel IDs to team/channel names")
+	fmt.Fprintln(os.Stderr, "  teams          Resolve team IDs to team names")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Run 'mm-csv-parser <subcommand> -h' for flags specific to that subcommand.")
+}
 
+// Main section
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	subcommand, args := os.Args[1], os.Args[2:]
+	switch subcommand {
+	case "users":
+		runUsersCommand(args)
+	case "users-reverse":
+		runUsersReverseCommand(args)
+	case "channels":
+		runChannelsCommand(args)
+	case "teams":
+		runTeamsCommand(args)
+	case "-h", "-help", "--help", "help":
+		printUsage()
+	default:
+		LogMessage(errorLevel, "Unknown subcommand: "+subcommand)
+		printUsage()
+		os.Exit(1)
+	}
 }